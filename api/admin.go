@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const adminCookiesPath = "/admin/cookies"
+
+// adminAuthorized checks the Authorization: Bearer <ADMIN_PASSWORD> header
+// against the ADMIN_PASSWORD env var. If ADMIN_PASSWORD is unset, the admin
+// API is disabled entirely. The comparison runs in constant time so a
+// network attacker timing responses can't recover the password byte by byte.
+func adminAuthorized(r *http.Request) bool {
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		return false
+	}
+	authHeader := r.Header.Get("Authorization")
+	provided := strings.TrimPrefix(authHeader, "Bearer ")
+
+	want := sha256.Sum256([]byte(password))
+	got := sha256.Sum256([]byte(provided))
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1
+}
+
+// handleAdminCookies dispatches /admin/cookies and /admin/cookies/:idx.
+func handleAdminCookies(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(r) {
+		http.Error(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, adminCookiesPath)
+	rest = strings.Trim(rest, "/")
+
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(defaultPool.Snapshot())
+		case http.MethodPost:
+			var body struct {
+				Cookies []string `json:"cookies"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("请求格式错误: %v", err), http.StatusBadRequest)
+				return
+			}
+			defaultPool.Load(body.Cookies)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(defaultPool.Snapshot())
+		default:
+			http.Error(w, "仅支持 GET/POST 请求", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	idx, err := strconv.Atoi(rest)
+	if err != nil {
+		http.Error(w, "无效的索引", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "仅支持 DELETE 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := defaultPool.Remove(idx); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(defaultPool.Snapshot())
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const authModeEnvVar = "AUTH_MODE" // cookie|har, default cookie
+
+// AuthSession is a credential picked for a single outbound request.
+type AuthSession interface {
+	// Apply sets the headers needed to authenticate as this credential.
+	Apply(req *http.Request)
+	// Identity returns a stable string identifying the credential. It is
+	// recorded on ConversationEntry.Identity so a later turn can be pinned
+	// back to it via PickIdentity; it is not part of the conversation key.
+	Identity() string
+	ReportFailure(statusCode int, err error)
+	ReportSuccess()
+}
+
+// AuthProvider selects an AuthSession per request. CookieProvider (today's
+// raw-cookie pool) and HarProvider (replaying a captured browser session)
+// are the two implementations; AUTH_MODE picks between them.
+type AuthProvider interface {
+	Pick() (AuthSession, error)
+	// PickIdentity returns the session whose Identity() equals identity,
+	// bypassing round-robin, so a cached conversation can be replayed
+	// against the exact credential that created it.
+	PickIdentity(identity string) (AuthSession, error)
+}
+
+var defaultAuthProvider = newAuthProvider()
+
+func newAuthProvider() AuthProvider {
+	if strings.ToLower(os.Getenv(authModeEnvVar)) != "har" {
+		return &CookieProvider{pool: defaultPool}
+	}
+
+	dir := harDir()
+	if provider, err := newHarProvider(dir); err == nil {
+		return provider
+	}
+
+	// No HAR captures exist yet: use cookie auth for now, but keep watching
+	// dir so that dropping in the first capture promotes us to a
+	// HarProvider without a restart.
+	wrapper := &switchableAuthProvider{current: &CookieProvider{pool: defaultPool}}
+	watchForFirstHarCapture(dir, wrapper)
+	return wrapper
+}
+
+// CookieProvider is today's behavior: a pool of raw Abacus cookies.
+type CookieProvider struct {
+	pool *Pool
+}
+
+func (p *CookieProvider) Pick() (AuthSession, error) {
+	return p.pool.Pick()
+}
+
+func (p *CookieProvider) PickIdentity(identity string) (AuthSession, error) {
+	return p.pool.PickByIdentity(identity)
+}
+
+// switchableAuthProvider forwards to whichever AuthProvider is current,
+// letting newAuthProvider hand out a stable value that can later be
+// promoted from CookieProvider to HarProvider in place.
+type switchableAuthProvider struct {
+	mu      sync.RWMutex
+	current AuthProvider
+}
+
+func (s *switchableAuthProvider) Pick() (AuthSession, error) {
+	return s.get().Pick()
+}
+
+func (s *switchableAuthProvider) PickIdentity(identity string) (AuthSession, error) {
+	return s.get().PickIdentity(identity)
+}
+
+func (s *switchableAuthProvider) get() AuthProvider {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+func (s *switchableAuthProvider) set(p AuthProvider) {
+	s.mu.Lock()
+	s.current = p
+	s.mu.Unlock()
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltConversationsBucket = []byte("conversations")
+
+// boltConversationStore is the ConversationStore backend selected with
+// CONVERSATION_STORE=bolt, for durable single-instance persistence across
+// restarts without an external dependency.
+type boltConversationStore struct {
+	db  *bolt.DB
+	ttl time.Duration
+}
+
+func newBoltConversationStore(ttl time.Duration) (*boltConversationStore, error) {
+	path := os.Getenv("BOLT_PATH")
+	if path == "" {
+		path = "conversations.db"
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltConversationsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltConversationStore{db: db, ttl: ttl}, nil
+}
+
+func (s *boltConversationStore) Get(key string) (ConversationEntry, bool) {
+	var entry ConversationEntry
+	found := false
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltConversationsBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil
+		}
+		found = true
+		return nil
+	})
+	if !found || entry.expired() {
+		return ConversationEntry{}, false
+	}
+	return entry, true
+}
+
+func (s *boltConversationStore) Set(key string, entry ConversationEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltConversationsBucket).Put([]byte(key), data)
+	})
+}
+
+func (s *boltConversationStore) Delete(key string) {
+	s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltConversationsBucket).Delete([]byte(key))
+	})
+}
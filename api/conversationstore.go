@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	conversationStoreEnvVar = "CONVERSATION_STORE" // memory|redis|bolt, default memory
+	conversationTTLEnvVar   = "CONVERSATION_TTL_SECONDS"
+	defaultConversationTTL  = 30 * time.Minute
+	memoryStoreCapacity     = 1000
+)
+
+// ConversationEntry is what gets cached per conversation-history prefix.
+// Identity records which AuthSession created the upstream conversation, so a
+// later turn can be pinned back to that exact credential instead of whatever
+// the pool's round-robin hands out next; see AuthProvider.PickIdentity.
+type ConversationEntry struct {
+	DeploymentConversationId string    `json:"deploymentConversationId"`
+	ExternalApplicationId    string    `json:"externalApplicationId"`
+	Identity                 string    `json:"identity"`
+	ExpiresAt                time.Time `json:"expiresAt"`
+}
+
+func (e ConversationEntry) expired() bool {
+	return time.Now().After(e.ExpiresAt)
+}
+
+// ConversationStore maps a conversation-history prefix key to the Abacus
+// deploymentConversationId it was assigned, so multi-turn chats reuse the
+// same upstream conversation instead of creating one per request.
+type ConversationStore interface {
+	Get(key string) (ConversationEntry, bool)
+	Set(key string, entry ConversationEntry)
+	Delete(key string)
+}
+
+var conversationTTLValue = loadConversationTTL()
+var defaultConversationStore = newConversationStore(conversationTTLValue)
+
+func loadConversationTTL() time.Duration {
+	if s := os.Getenv(conversationTTLEnvVar); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return defaultConversationTTL
+}
+
+// newConversationStore picks a backend based on CONVERSATION_STORE, falling
+// back to the in-memory LRU when the requested backend isn't configured or
+// fails to connect.
+func newConversationStore(ttl time.Duration) ConversationStore {
+	switch strings.ToLower(os.Getenv(conversationStoreEnvVar)) {
+	case "redis":
+		if store, err := newRedisConversationStore(ttl); err == nil {
+			return store
+		}
+	case "bolt":
+		if store, err := newBoltConversationStore(ttl); err == nil {
+			return store
+		}
+	}
+	return newMemoryConversationStore(ttl, memoryStoreCapacity)
+}
+
+// conversationKey hashes the model and the caller's own messages (role
+// "user") so that requests sharing a history reuse the same Abacus
+// conversation. It deliberately ignores assistant replies: a client resends
+// them verbatim as context, but they add nothing a look-up needs, and
+// dropping them lets the key computed when a conversation is first created
+// (from every user message received so far) line up with the key computed
+// on the next turn's look-up (from every user message in the prefix, i.e.
+// every message but the newest one the client just sent).
+//
+// Credential identity is intentionally not part of the key: which AuthSession
+// created the upstream conversation is tracked on ConversationEntry.Identity
+// instead, so a lookup doesn't require already knowing which credential to
+// use.
+func conversationKey(model string, userMessages []Message) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	for _, m := range userMessages {
+		h.Write([]byte{0})
+		h.Write([]byte(m.Content))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// filterUserMessages returns the subset of msgs sent with role "user", in
+// order, for use as conversationKey input.
+func filterUserMessages(msgs []Message) []Message {
+	var out []Message
+	for _, m := range msgs {
+		if m.Role == "user" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
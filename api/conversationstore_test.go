@@ -0,0 +1,39 @@
+package handler
+
+import "testing"
+
+// TestConversationKeyFirstTurnMessagesDiffer guards against the conversation
+// key colliding on every brand-new chat: two unrelated callers whose first
+// message differs must not be handed the same cache key, or the second
+// caller's message would get redirected into the first caller's upstream
+// conversation.
+func TestConversationKeyFirstTurnMessagesDiffer(t *testing.T) {
+	first := filterUserMessages([]Message{{Role: "user", Content: "hello from user A"}})
+	second := filterUserMessages([]Message{{Role: "user", Content: "hello from user B"}})
+
+	keyA := conversationKey("gpt-4o", first)
+	keyB := conversationKey("gpt-4o", second)
+
+	if keyA == keyB {
+		t.Fatalf("expected distinct first-turn messages to produce distinct keys, both got %q", keyA)
+	}
+}
+
+// TestConversationKeyReusesAcrossTurns checks that the key computed when a
+// conversation is created (from every user message received so far) matches
+// the key computed on the next turn's look-up (from every user message in
+// the prefix, i.e. everything but the newest message just sent).
+func TestConversationKeyReusesAcrossTurns(t *testing.T) {
+	turn1Messages := []Message{{Role: "user", Content: "hi"}}
+	setKey := conversationKey("gpt-4o", filterUserMessages(turn1Messages))
+
+	turn2Prefix := []Message{
+		{Role: "user", Content: "hi"},
+		{Role: "assistant", Content: "hello, how can I help?"},
+	}
+	lookupKey := conversationKey("gpt-4o", filterUserMessages(turn2Prefix))
+
+	if setKey != lookupKey {
+		t.Fatalf("expected turn 2's lookup key to match turn 1's stored key, got %q vs %q", lookupKey, setKey)
+	}
+}
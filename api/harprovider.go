@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const harDirEnvVar = "HAR_DIR"
+const defaultHarDir = "har"
+
+func harDir() string {
+	if dir := os.Getenv(harDirEnvVar); dir != "" {
+		return dir
+	}
+	return defaultHarDir
+}
+
+// harEntry holds the headers replayed from one captured browser session.
+type harEntry struct {
+	path    string
+	headers map[string]string
+	invalid bool
+}
+
+// HarProvider is the AUTH_MODE=har backend: it round-robins across .har
+// captures in a directory, replaying the Cookie/CSRF/anti-bot headers
+// recorded on their createDeploymentConversation request, and watches the
+// directory for newly-dropped captures.
+type HarProvider struct {
+	mu      sync.Mutex
+	dir     string
+	entries []*harEntry
+	next    int
+	watcher *fsnotify.Watcher
+}
+
+func newHarProvider(dir string) (*HarProvider, error) {
+	p := &HarProvider{dir: dir}
+	p.reload()
+	if len(p.entries) == 0 {
+		return nil, fmt.Errorf("目录中未找到可用的 HAR 文件: %s", dir)
+	}
+	p.watch()
+	return p, nil
+}
+
+// reload (re-)scans the directory, adding any .har file not already loaded.
+func (p *HarProvider) reload() {
+	files, err := filepath.Glob(filepath.Join(p.dir, "*.har"))
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range files {
+		if p.hasPathLocked(f) {
+			continue
+		}
+		entry, err := parseHarFile(f)
+		if err != nil {
+			continue
+		}
+		p.entries = append(p.entries, entry)
+	}
+}
+
+func (p *HarProvider) hasPathLocked(path string) bool {
+	for _, e := range p.entries {
+		if e.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// watch hot-reloads newly added .har captures via fsnotify.
+func (p *HarProvider) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	if err := watcher.Add(p.dir); err != nil {
+		watcher.Close()
+		return
+	}
+	p.watcher = watcher
+
+	go func() {
+		for event := range watcher.Events {
+			if event.Op&(fsnotify.Create|fsnotify.Write) != 0 && strings.HasSuffix(event.Name, ".har") {
+				p.reload()
+			}
+		}
+	}()
+}
+
+// watchForFirstHarCapture starts a best-effort fsnotify watch on dir for the
+// case where AUTH_MODE=har is set before any .har files have been dropped
+// in. As soon as one parses successfully it promotes wrapper from
+// CookieProvider to a real HarProvider, which then watches dir itself for
+// subsequent captures. If dir doesn't exist yet or can't be watched, this is
+// a no-op and the process stays on cookie auth until restarted.
+func watchForFirstHarCapture(dir string, wrapper *switchableAuthProvider) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return
+	}
+
+	go func() {
+		defer watcher.Close()
+		for event := range watcher.Events {
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 || !strings.HasSuffix(event.Name, ".har") {
+				continue
+			}
+			if provider, err := newHarProvider(dir); err == nil {
+				wrapper.set(provider)
+				return
+			}
+		}
+	}()
+}
+
+// Pick round-robins across HAR entries not yet marked invalid.
+func (p *HarProvider) Pick() (AuthSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.entries)
+	if n == 0 {
+		return nil, errNoHealthyCookies
+	}
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		entry := p.entries[idx]
+		if !entry.invalid {
+			p.next = (idx + 1) % n
+			return &harSession{provider: p, entry: entry}, nil
+		}
+	}
+	return nil, errNoHealthyCookies
+}
+
+// PickIdentity returns the session for the HAR capture at the given path,
+// bypassing round-robin, so a cached conversation can be replayed against
+// the capture that created it.
+func (p *HarProvider) PickIdentity(identity string) (AuthSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, entry := range p.entries {
+		if entry.path != identity {
+			continue
+		}
+		if entry.invalid {
+			return nil, errNoHealthyCookies
+		}
+		return &harSession{provider: p, entry: entry}, nil
+	}
+	return nil, errNoHealthyCookies
+}
+
+type harSession struct {
+	provider *HarProvider
+	entry    *harEntry
+}
+
+func (s *harSession) Apply(req *http.Request) {
+	for name, value := range s.entry.headers {
+		req.Header.Set(name, value)
+	}
+}
+
+func (s *harSession) Identity() string {
+	return s.entry.path
+}
+
+func (s *harSession) ReportFailure(statusCode int, err error) {
+	if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		s.provider.mu.Lock()
+		s.entry.invalid = true
+		s.provider.mu.Unlock()
+	}
+}
+
+func (s *harSession) ReportSuccess() {}
+
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// parseHarFile extracts the replayable headers from the
+// createDeploymentConversation request recorded in a HAR capture.
+func parseHarFile(path string) (*harEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range har.Log.Entries {
+		if !strings.Contains(entry.Request.URL, "createDeploymentConversation") {
+			continue
+		}
+		headers := make(map[string]string)
+		for _, h := range entry.Request.Headers {
+			if isReplayableHarHeader(h.Name) {
+				headers[http.CanonicalHeaderKey(h.Name)] = h.Value
+			}
+		}
+		if _, ok := headers["Cookie"]; !ok {
+			return nil, fmt.Errorf("HAR 文件缺少 Cookie 头: %s", path)
+		}
+		return &harEntry{path: path, headers: headers}, nil
+	}
+
+	return nil, fmt.Errorf("HAR 文件中未找到 createDeploymentConversation 请求: %s", path)
+}
+
+// isReplayableHarHeader keeps the auth-relevant headers a browser sent
+// (cookie, CSRF/anti-bot tokens, client hints) and drops everything else
+// (Host, Content-Length, ...) that shouldn't be replayed verbatim.
+func isReplayableHarHeader(name string) bool {
+	lower := strings.ToLower(name)
+	switch {
+	case lower == "cookie":
+		return true
+	case lower == "x-abacus-org-host":
+		return true
+	case strings.HasPrefix(lower, "sec-ch-ua"):
+		return true
+	case strings.Contains(lower, "csrf"):
+		return true
+	case strings.Contains(lower, "anti-bot"):
+		return true
+	case strings.HasPrefix(lower, "x-abacus"):
+		return true
+	}
+	return false
+}
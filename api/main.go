@@ -1,438 +1,663 @@
-package handler
-
-import (
-	"bufio"
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"strings"
-	"time"
-
-	"github.com/google/uuid"
-)
-
-type OpenAIRequest struct {
-	Messages []Message `json:"messages"`
-	Model    string    `json:"model"`
-}
-
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type CreateConversationRequest struct {
-	DeploymentId          string `json:"deploymentId"`
-	Name                  string `json:"name"`
-	ExternalApplicationId string `json:"externalApplicationId"`
-}
-
-type CreateConversationResponse struct {
-	Success bool `json:"success"`
-	Result  struct {
-		DeploymentConversationId string `json:"deploymentConversationId"`
-		ExternalApplicationId    string `json:"externalApplicationId"`
-	} `json:"result"`
-}
-
-type ChatRequest struct {
-	RequestId                string     `json:"requestId"`
-	DeploymentConversationId string     `json:"deploymentConversationId"`
-	Message                  string     `json:"message"`
-	IsDesktop                bool       `json:"isDesktop"`
-	ChatConfig               ChatConfig `json:"chatConfig"`
-	LlmName                  string     `json:"llmName"`
-	ExternalApplicationId    string     `json:"externalApplicationId"`
-}
-
-type ChatConfig struct {
-	Timezone string `json:"timezone"`
-	Language string `json:"language"`
-}
-
-type OpenAIStreamResponse struct {
-	Id      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Delta struct {
-			Content string `json:"content"`
-		} `json:"delta"`
-		Index        int    `json:"index"`
-		FinishReason string `json:"finish_reason,omitempty"`
-	} `json:"choices"`
-}
-
-type OpenAIResponse struct {
-	Id      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-		FinishReason string `json:"finish_reason"`
-	} `json:"choices"`
-}
-
-type AbacusResponse struct {
-	Type              string  `json:"type"`
-	Temp              bool    `json:"temp"`
-	IsSpinny          bool    `json:"isSpinny"`
-	Segment           string  `json:"segment"`
-	Title             string  `json:"title"`
-	IsGeneratingImage bool    `json:"isGeneratingImage"`
-	MessageId         string  `json:"messageId"`
-	Counter           int     `json:"counter"`
-	Message_id        string  `json:"message_id"`
-	Token             *string `json:"token,omitempty"`
-	End               bool    `json:"end,omitempty"`
-	Success           bool    `json:"success,omitempty"`
-}
-
-func Handler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/v1/chat/completions" {
-		w.Header().Set("Content-Type", "application/json")
-		response := map[string]string{
-			"status":  "Abacus2Api Service Running...",
-			"message": "MoLoveSze...",
-		}
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "仅支持 POST 请求", http.StatusMethodNotAllowed)
-		return
-	}
-
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, "未提供有效的 Authorization header", http.StatusUnauthorized)
-		return
-	}
-	cookie := strings.TrimPrefix(authHeader, "Bearer ")
-
-	var requestBody struct {
-		Messages []struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"messages"`
-		Model  string `json:"model"`
-		Stream bool   `json:"stream"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, fmt.Sprintf("请求格式错误: %v", err), http.StatusBadRequest)
-		return
-	}
-
-	isStream := requestBody.Stream
-
-	convResp, err := createConversation(cookie)
-	if err != nil {
-		http.Error(w, "创建会话失败", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	message := ""
-	var systemPrompt string
-	var contextMessages []Message
-
-	if len(requestBody.Messages) > 0 {
-		message = requestBody.Messages[len(requestBody.Messages)-1].Content
-		for _, msg := range requestBody.Messages[:len(requestBody.Messages)-1] {
-			if msg.Role == "system" {
-				systemPrompt = msg.Content
-			} else {
-				contextMessages = append(contextMessages, Message{Role: msg.Role, Content: msg.Content})
-			}
-		}
-	}
-
-	fullMessage := message
-	if systemPrompt != "" {
-		fullMessage = fmt.Sprintf("System: %s\n\n%s", systemPrompt, message)
-	}
-	if len(contextMessages) > 0 {
-		contextStr := ""
-		for _, ctx := range contextMessages {
-			contextStr += fmt.Sprintf("%s: %s\n", ctx.Role, ctx.Content)
-		}
-		fullMessage = fmt.Sprintf("Previous conversation:\n%s\nCurrent message: %s", contextStr, message)
-	}
-
-	chatReq := ChatRequest{
-		RequestId:                uuid.New().String(),
-		DeploymentConversationId: convResp.Result.DeploymentConversationId,
-		Message:                  fullMessage,
-		IsDesktop:                true,
-		ChatConfig: ChatConfig{
-			Timezone: "Asia/Hong_Kong",
-			Language: "zh-CN",
-		},
-		LlmName:               requestBody.Model,
-		ExternalApplicationId: convResp.Result.ExternalApplicationId,
-	}
-
-	if isStream {
-		err = sendStreamResponse(w, cookie, chatReq)
-	} else {
-		err = sendNonStreamResponse(w, cookie, chatReq)
-	}
-
-	if err != nil {
-		http.Error(w, "发送消息失败", http.StatusInternalServerError)
-		return
-	}
-}
-
-func createConversation(cookie string) (*CreateConversationResponse, error) {
-	reqBody := CreateConversationRequest{
-		DeploymentId:          "d892fb336",
-		Name:                  "New Chat",
-		ExternalApplicationId: "ca852b1e2",
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", "https://pa002.abacus.ai/cluster-proxy/api/createDeploymentConversation", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
-	}
-
-	setHeaders(req, cookie)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	var result CreateConversationResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
-
-	return &result, nil
-}
-
-func sendStreamResponse(w http.ResponseWriter, cookie string, chatReq ChatRequest) error {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	jsonData, err := json.Marshal(chatReq)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest("POST", "https://pa002.abacus.ai/api/_chatLLMSendMessageSSE", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	setHeaders(req, cookie)
-	req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	reader := bufio.NewReader(resp.Body)
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				fmt.Fprintf(w, "data: [DONE]\n\n")
-				return nil
-			}
-			return err
-		}
-
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		var abacusResp AbacusResponse
-		if err := json.Unmarshal([]byte(line), &abacusResp); err != nil {
-			continue
-		}
-
-		if abacusResp.Type == "text" && abacusResp.Title != "Thinking..." {
-			streamResp := OpenAIStreamResponse{
-				Id:      uuid.New().String(),
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   chatReq.LlmName,
-				Choices: []struct {
-					Delta struct {
-						Content string `json:"content"`
-					} `json:"delta"`
-					Index        int    `json:"index"`
-					FinishReason string `json:"finish_reason,omitempty"`
-				}{
-					{
-						Delta: struct {
-							Content string `json:"content"`
-						}{
-							Content: abacusResp.Segment,
-						},
-						Index: 0,
-					},
-				},
-			}
-
-			jsonResp, err := json.Marshal(streamResp)
-			if err != nil {
-				return err
-			}
-
-			fmt.Fprintf(w, "data: %s\n\n", jsonResp)
-		}
-
-		if abacusResp.End {
-			endResp := OpenAIStreamResponse{
-				Id:      uuid.New().String(),
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   chatReq.LlmName,
-				Choices: []struct {
-					Delta struct {
-						Content string `json:"content"`
-					} `json:"delta"`
-					Index        int    `json:"index"`
-					FinishReason string `json:"finish_reason,omitempty"`
-				}{
-					{
-						Delta: struct {
-							Content string `json:"content"`
-						}{},
-						Index:        0,
-						FinishReason: "stop",
-					},
-				},
-			}
-			jsonResp, _ := json.Marshal(endResp)
-			fmt.Fprintf(w, "data: %s\n\n", jsonResp)
-			fmt.Fprintf(w, "data: [DONE]\n\n")
-			return nil
-		}
-	}
-
-	return nil
-}
-
-func sendNonStreamResponse(w http.ResponseWriter, cookie string, chatReq ChatRequest) error {
-	w.Header().Set("Content-Type", "application/json")
-
-	jsonData, err := json.Marshal(chatReq)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest("POST", "https://pa002.abacus.ai/api/_chatLLMSendMessageSSE", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	setHeaders(req, cookie)
-	req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	reader := bufio.NewReader(resp.Body)
-	var content strings.Builder
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		var abacusResp AbacusResponse
-		if err := json.Unmarshal([]byte(line), &abacusResp); err != nil {
-			continue
-		}
-
-		if abacusResp.Type == "text" && abacusResp.Title != "Thinking..." {
-			content.WriteString(abacusResp.Segment)
-		}
-
-		if abacusResp.End {
-			break
-		}
-	}
-
-	openAIResp := OpenAIResponse{
-		Id:      uuid.New().String(),
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   chatReq.LlmName,
-		Choices: []struct {
-			Message struct {
-				Role    string `json:"role"`
-				Content string `json:"content"`
-			} `json:"message"`
-			FinishReason string `json:"finish_reason"`
-		}{
-			{
-				Message: struct {
-					Role    string `json:"role"`
-					Content string `json:"content"`
-				}{
-					Role:    "assistant",
-					Content: content.String(),
-				},
-				FinishReason: "stop",
-			},
-		},
-	}
-
-	return json.NewEncoder(w).Encode(openAIResp)
-}
-
-func setHeaders(req *http.Request, cookie string) {
-	req.Header.Set("sec-ch-ua-platform", "Windows")
-	req.Header.Set("sec-ch-ua", "\"Not(A:Brand\";v=\"99\", \"Microsoft Edge\";v=\"133\", \"Chromium\";v=\"133\"")
-	req.Header.Set("sec-ch-ua-mobile", "?0")
-	req.Header.Set("X-Abacus-Org-Host", "apps")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36 Edg/133.0.0.0")
-	req.Header.Set("Sec-Fetch-Site", "same-site")
-	req.Header.Set("Sec-Fetch-Mode", "cors")
-	req.Header.Set("Sec-Fetch-Dest", "empty")
-	req.Header.Set("host", "pa002.abacus.ai")
-	req.Header.Set("Cookie", cookie)
-}
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upstream endpoints, overridable in tests.
+var (
+	abacusCreateConversationURL = "https://pa002.abacus.ai/cluster-proxy/api/createDeploymentConversation"
+	abacusChatURL               = "https://pa002.abacus.ai/api/_chatLLMSendMessageSSE"
+)
+
+type OpenAIRequest struct {
+	Messages []Message `json:"messages"`
+	Model    string    `json:"model"`
+}
+
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type CreateConversationRequest struct {
+	DeploymentId          string `json:"deploymentId"`
+	Name                  string `json:"name"`
+	ExternalApplicationId string `json:"externalApplicationId"`
+}
+
+type CreateConversationResponse struct {
+	Success bool `json:"success"`
+	Result  struct {
+		DeploymentConversationId string `json:"deploymentConversationId"`
+		ExternalApplicationId    string `json:"externalApplicationId"`
+	} `json:"result"`
+}
+
+type ChatRequest struct {
+	RequestId                string     `json:"requestId"`
+	DeploymentConversationId string     `json:"deploymentConversationId"`
+	Message                  string     `json:"message"`
+	IsDesktop                bool       `json:"isDesktop"`
+	ChatConfig               ChatConfig `json:"chatConfig"`
+	LlmName                  string     `json:"llmName"`
+	ExternalApplicationId    string     `json:"externalApplicationId"`
+}
+
+type ChatConfig struct {
+	Timezone string `json:"timezone"`
+	Language string `json:"language"`
+}
+
+type Delta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type StreamChoice struct {
+	Delta        Delta  `json:"delta"`
+	Index        int    `json:"index"`
+	FinishReason string `json:"finish_reason,omitempty"`
+}
+
+type OpenAIStreamResponse struct {
+	Id      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []StreamChoice `json:"choices"`
+}
+
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ResponseChoice struct {
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type OpenAIResponse struct {
+	Id      string           `json:"id"`
+	Object  string           `json:"object"`
+	Created int64            `json:"created"`
+	Model   string           `json:"model"`
+	Choices []ResponseChoice `json:"choices"`
+	Usage   Usage            `json:"usage"`
+}
+
+type AbacusResponse struct {
+	Type              string  `json:"type"`
+	Temp              bool    `json:"temp"`
+	IsSpinny          bool    `json:"isSpinny"`
+	Segment           string  `json:"segment"`
+	Title             string  `json:"title"`
+	IsGeneratingImage bool    `json:"isGeneratingImage"`
+	MessageId         string  `json:"messageId"`
+	Counter           int     `json:"counter"`
+	Message_id        string  `json:"message_id"`
+	Token             *string `json:"token,omitempty"`
+	End               bool    `json:"end,omitempty"`
+	Success           bool    `json:"success,omitempty"`
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, adminCookiesPath) {
+		handleAdminCookies(w, r)
+		return
+	}
+
+	if r.URL.Path == "/v1/models" {
+		handleModels(w, r)
+		return
+	}
+
+	if r.URL.Path != "/v1/chat/completions" {
+		w.Header().Set("Content-Type", "application/json")
+		response := map[string]string{
+			"status":  "Abacus2Api Service Running...",
+			"message": "MoLoveSze...",
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持 POST 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, fmt.Sprintf("请求格式错误: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	isStream := requestBody.Stream
+
+	message := ""
+	var systemPrompt string
+	var contextMessages []Message
+	var prefix []Message
+	var allMessages []Message
+
+	if len(requestBody.Messages) > 0 {
+		message = requestBody.Messages[len(requestBody.Messages)-1].Content
+		for _, msg := range requestBody.Messages[:len(requestBody.Messages)-1] {
+			prefix = append(prefix, Message{Role: msg.Role, Content: msg.Content})
+			if msg.Role == "system" {
+				systemPrompt = msg.Content
+			} else {
+				contextMessages = append(contextMessages, Message{Role: msg.Role, Content: msg.Content})
+			}
+		}
+		for _, msg := range requestBody.Messages {
+			allMessages = append(allMessages, Message{Role: msg.Role, Content: msg.Content})
+		}
+	}
+
+	lookupKey := conversationKey(requestBody.Model, filterUserMessages(prefix))
+
+	var client AuthSession
+	var deploymentConversationId, externalApplicationId string
+	var fullMessage string
+	storeKey := lookupKey
+	reused := false
+
+	if entry, ok := defaultConversationStore.Get(lookupKey); ok {
+		// Reused conversation: replay against the exact credential that
+		// created it upstream, since the deploymentConversationId belongs
+		// to that account, not to the pool as a whole. If that credential
+		// is no longer available (removed, quarantined), fall through to
+		// starting a fresh conversation below.
+		if pinned, err := defaultAuthProvider.PickIdentity(entry.Identity); err == nil {
+			client = pinned
+			deploymentConversationId = entry.DeploymentConversationId
+			externalApplicationId = entry.ExternalApplicationId
+			fullMessage = message
+			reused = true
+		}
+	}
+
+	if client == nil {
+		picked, err := defaultAuthProvider.Pick()
+		if err != nil {
+			http.Error(w, "没有可用的 cookie", http.StatusServiceUnavailable)
+			return
+		}
+		client = picked
+
+		convResp, err := createConversation(client)
+		if err != nil {
+			http.Error(w, "创建会话失败", http.StatusInternalServerError)
+			return
+		}
+		deploymentConversationId = convResp.Result.DeploymentConversationId
+		externalApplicationId = convResp.Result.ExternalApplicationId
+		storeKey = conversationKey(requestBody.Model, filterUserMessages(allMessages))
+		defaultConversationStore.Set(storeKey, ConversationEntry{
+			DeploymentConversationId: deploymentConversationId,
+			ExternalApplicationId:    externalApplicationId,
+			Identity:                 client.Identity(),
+			ExpiresAt:                time.Now().Add(conversationTTLValue),
+		})
+
+		fullMessage = message
+		if systemPrompt != "" {
+			fullMessage = fmt.Sprintf("System: %s\n\n%s", systemPrompt, message)
+		}
+		if len(contextMessages) > 0 {
+			contextStr := ""
+			for _, ctx := range contextMessages {
+				contextStr += fmt.Sprintf("%s: %s\n", ctx.Role, ctx.Content)
+			}
+			fullMessage = fmt.Sprintf("Previous conversation:\n%s\nCurrent message: %s", contextStr, message)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	chatReq := ChatRequest{
+		RequestId:                uuid.New().String(),
+		DeploymentConversationId: deploymentConversationId,
+		Message:                  fullMessage,
+		IsDesktop:                true,
+		ChatConfig: ChatConfig{
+			Timezone: "Asia/Hong_Kong",
+			Language: "zh-CN",
+		},
+		LlmName:               defaultModelMap.Resolve(requestBody.Model),
+		ExternalApplicationId: externalApplicationId,
+	}
+
+	promptTokens := 0
+	for _, msg := range requestBody.Messages {
+		promptTokens += countTokens(msg.Content)
+	}
+
+	var err error
+	if isStream {
+		err = sendStreamResponse(w, r, client, chatReq, requestBody.Model)
+	} else {
+		err = sendNonStreamResponse(w, client, chatReq, requestBody.Model, promptTokens)
+	}
+
+	if err != nil {
+		// The cached conversation may no longer exist upstream; drop it so
+		// the next request with this prefix creates a fresh one.
+		defaultConversationStore.Delete(storeKey)
+		http.Error(w, "发送消息失败", http.StatusInternalServerError)
+		return
+	}
+
+	if reused {
+		// Carry the reused conversation forward under the key the next turn
+		// will look up, which includes this turn's user message.
+		defaultConversationStore.Set(conversationKey(requestBody.Model, filterUserMessages(allMessages)), ConversationEntry{
+			DeploymentConversationId: deploymentConversationId,
+			ExternalApplicationId:    externalApplicationId,
+			Identity:                 client.Identity(),
+			ExpiresAt:                time.Now().Add(conversationTTLValue),
+		})
+	}
+}
+
+// handleModels implements GET /v1/models so that OpenAI-compatible clients
+// (LibreChat, Chatbox, the OpenAI SDK, ...) can discover the configured
+// model_map entries.
+func handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "仅支持 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type modelEntry struct {
+		Id      string `json:"id"`
+		Object  string `json:"object"`
+		OwnedBy string `json:"owned_by"`
+	}
+
+	models := defaultModelMap.Models()
+	data := make([]modelEntry, 0, len(models))
+	for _, id := range models {
+		data = append(data, modelEntry{Id: id, Object: "model", OwnedBy: "abacus"})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func createConversation(client AuthSession) (*CreateConversationResponse, error) {
+	reqBody := CreateConversationRequest{
+		DeploymentId:          "d892fb336",
+		Name:                  "New Chat",
+		ExternalApplicationId: "ca852b1e2",
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", abacusCreateConversationURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	client.Apply(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := defaultProxyPool.Pick()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		defaultProxyPool.ReportFailure(httpClient)
+		client.ReportFailure(0, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if isUnhealthyStatus(resp.StatusCode) {
+		client.ReportFailure(resp.StatusCode, nil)
+		return nil, fmt.Errorf("创建会话失败，上游状态码: %d", resp.StatusCode)
+	}
+
+	var result CreateConversationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		client.ReportFailure(resp.StatusCode, err)
+		return nil, err
+	}
+
+	if !result.Success {
+		client.ReportFailure(resp.StatusCode, nil)
+		return nil, fmt.Errorf("创建会话失败，上游返回 success=false")
+	}
+
+	client.ReportSuccess()
+	return &result, nil
+}
+
+// isUnhealthyStatus reports whether statusCode should quarantine the cookie
+// that produced it.
+func isUnhealthyStatus(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized ||
+		statusCode == http.StatusForbidden ||
+		statusCode == http.StatusTooManyRequests
+}
+
+// streamIdleTimeout is how long sendStreamResponse waits for upstream bytes
+// before closing the connection, overridable with STREAM_IDLE_TIMEOUT_SECONDS.
+var streamIdleTimeout = loadStreamIdleTimeout()
+
+const streamIdleTimeoutEnvVar = "STREAM_IDLE_TIMEOUT_SECONDS"
+const defaultStreamIdleTimeout = 30 * time.Second
+const streamLineBuffer = 32
+
+func loadStreamIdleTimeout() time.Duration {
+	if s := os.Getenv(streamIdleTimeoutEnvVar); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return defaultStreamIdleTimeout
+}
+
+// writeSSEChunk marshals v as a "data: ...\n\n" frame and flushes it
+// immediately so intermediary proxies don't buffer the whole response.
+func writeSSEChunk(w http.ResponseWriter, flusher http.Flusher, v interface{}) error {
+	jsonResp, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", jsonResp); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// writeSSEError surfaces an upstream failure as both the HTTP status (if the
+// response hasn't been written yet) and an SSE error event.
+func writeSSEError(w http.ResponseWriter, flusher http.Flusher, statusCode int, cause error) {
+	if statusCode >= http.StatusBadRequest {
+		w.WriteHeader(statusCode)
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": cause.Error(),
+			"type":    "upstream_error",
+			"code":    statusCode,
+		},
+	})
+	fmt.Fprintf(w, "data: %s\n\n", payload)
+	flusher.Flush()
+}
+
+func sendStreamResponse(w http.ResponseWriter, r *http.Request, client AuthSession, chatReq ChatRequest, displayModel string) error {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("响应写入器不支持流式刷新")
+	}
+
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), "POST", abacusChatURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	client.Apply(req)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+
+	httpClient := defaultProxyPool.Pick()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		defaultProxyPool.ReportFailure(httpClient)
+		client.ReportFailure(0, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if isUnhealthyStatus(resp.StatusCode) {
+		client.ReportFailure(resp.StatusCode, nil)
+		err := fmt.Errorf("发送消息失败，上游状态码: %d", resp.StatusCode)
+		writeSSEError(w, flusher, resp.StatusCode, err)
+		return err
+	}
+	client.ReportSuccess()
+
+	if err := writeSSEChunk(w, flusher, OpenAIStreamResponse{
+		Id:      uuid.New().String(),
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   displayModel,
+		Choices: []StreamChoice{{Delta: Delta{Role: "assistant"}, Index: 0}},
+	}); err != nil {
+		return err
+	}
+
+	lines := make(chan string, streamLineBuffer)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if line = strings.TrimSpace(line); line != "" {
+				select {
+				case lines <- line:
+				case <-done:
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr <- err
+				}
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			resp.Body.Close()
+			return r.Context().Err()
+
+		case err := <-readErr:
+			return err
+
+		case <-time.After(streamIdleTimeout):
+			resp.Body.Close()
+			err := fmt.Errorf("上游响应空闲超时（%s 无数据）", streamIdleTimeout)
+			writeSSEError(w, flusher, 0, err)
+			return err
+
+		case line, ok := <-lines:
+			if !ok {
+				// lines closes both on a clean EOF and right after a failed
+				// read pushes to readErr; give the error a chance to win the
+				// race so a dropped connection isn't reported as success.
+				select {
+				case err := <-readErr:
+					return err
+				default:
+				}
+				fmt.Fprintf(w, "data: [DONE]\n\n")
+				flusher.Flush()
+				return nil
+			}
+
+			var abacusResp AbacusResponse
+			if err := json.Unmarshal([]byte(line), &abacusResp); err != nil {
+				continue
+			}
+
+			if abacusResp.Type == "text" && abacusResp.Title != "Thinking..." {
+				if err := writeSSEChunk(w, flusher, OpenAIStreamResponse{
+					Id:      uuid.New().String(),
+					Object:  "chat.completion.chunk",
+					Created: time.Now().Unix(),
+					Model:   displayModel,
+					Choices: []StreamChoice{{Delta: Delta{Content: abacusResp.Segment}, Index: 0}},
+				}); err != nil {
+					return err
+				}
+			}
+
+			if abacusResp.End {
+				if err := writeSSEChunk(w, flusher, OpenAIStreamResponse{
+					Id:      uuid.New().String(),
+					Object:  "chat.completion.chunk",
+					Created: time.Now().Unix(),
+					Model:   displayModel,
+					Choices: []StreamChoice{{Index: 0, FinishReason: "stop"}},
+				}); err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "data: [DONE]\n\n")
+				flusher.Flush()
+				return nil
+			}
+		}
+	}
+}
+
+func sendNonStreamResponse(w http.ResponseWriter, client AuthSession, chatReq ChatRequest, displayModel string, promptTokens int) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", abacusChatURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	client.Apply(req)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+
+	httpClient := defaultProxyPool.Pick()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		defaultProxyPool.ReportFailure(httpClient)
+		client.ReportFailure(0, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if isUnhealthyStatus(resp.StatusCode) {
+		client.ReportFailure(resp.StatusCode, nil)
+		return fmt.Errorf("发送消息失败，上游状态码: %d", resp.StatusCode)
+	}
+	client.ReportSuccess()
+
+	reader := bufio.NewReader(resp.Body)
+	var content strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var abacusResp AbacusResponse
+		if err := json.Unmarshal([]byte(line), &abacusResp); err != nil {
+			continue
+		}
+
+		if abacusResp.Type == "text" && abacusResp.Title != "Thinking..." {
+			content.WriteString(abacusResp.Segment)
+		}
+
+		if abacusResp.End {
+			break
+		}
+	}
+
+	completionTokens := countTokens(content.String())
+
+	openAIResp := OpenAIResponse{
+		Id:      uuid.New().String(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   displayModel,
+		Choices: []ResponseChoice{
+			{
+				Message:      ChatMessage{Role: "assistant", Content: content.String()},
+				FinishReason: "stop",
+			},
+		},
+		Usage: Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}
+
+	return json.NewEncoder(w).Encode(openAIResp)
+}
+
+func setHeaders(req *http.Request, cookie string) {
+	req.Header.Set("sec-ch-ua-platform", "Windows")
+	req.Header.Set("sec-ch-ua", "\"Not(A:Brand\";v=\"99\", \"Microsoft Edge\";v=\"133\", \"Chromium\";v=\"133\"")
+	req.Header.Set("sec-ch-ua-mobile", "?0")
+	req.Header.Set("X-Abacus-Org-Host", "apps")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36 Edg/133.0.0.0")
+	req.Header.Set("Sec-Fetch-Site", "same-site")
+	req.Header.Set("Sec-Fetch-Mode", "cors")
+	req.Header.Set("Sec-Fetch-Dest", "empty")
+	req.Header.Set("host", "pa002.abacus.ai")
+	req.Header.Set("Cookie", cookie)
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// memoryConversationStore is the default ConversationStore backend: an
+// in-memory LRU with TTL-based expiry, good enough for a single instance.
+type memoryConversationStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+type memoryStoreEntry struct {
+	key   string
+	value ConversationEntry
+}
+
+func newMemoryConversationStore(ttl time.Duration, capacity int) *memoryConversationStore {
+	return &memoryConversationStore{
+		ttl:      ttl,
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (s *memoryConversationStore) Get(key string) (ConversationEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return ConversationEntry{}, false
+	}
+	entry := el.Value.(*memoryStoreEntry)
+	if entry.value.expired() {
+		s.order.Remove(el)
+		delete(s.items, key)
+		return ConversationEntry{}, false
+	}
+	s.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (s *memoryConversationStore) Set(key string, value ConversationEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		el.Value.(*memoryStoreEntry).value = value
+		s.order.MoveToFront(el)
+		return
+	}
+
+	el := s.order.PushFront(&memoryStoreEntry{key: key, value: value})
+	s.items[key] = el
+	if s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		if oldest != nil {
+			s.order.Remove(oldest)
+			delete(s.items, oldest.Value.(*memoryStoreEntry).key)
+		}
+	}
+}
+
+func (s *memoryConversationStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.order.Remove(el)
+		delete(s.items, key)
+	}
+}
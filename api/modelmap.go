@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	modelMapFile   = "model_map.json"
+	modelMapEnvVar = "MODEL_MAP"
+)
+
+// ModelMap translates OpenAI-style model ids (e.g. "gpt-4o") to the
+// llmName values Abacus expects.
+type ModelMap struct {
+	mu      sync.RWMutex
+	mapping map[string]string
+}
+
+// defaultModelMap is loaded from model_map.json and/or the MODEL_MAP env
+// var (a JSON object) at startup, falling back to a built-in mapping.
+var defaultModelMap = NewModelMap(loadModelMapSeed())
+
+func loadModelMapSeed() map[string]string {
+	mapping := map[string]string{}
+	if data, err := os.ReadFile(modelMapFile); err == nil {
+		json.Unmarshal(data, &mapping)
+	}
+	if env := os.Getenv(modelMapEnvVar); env != "" {
+		var envMapping map[string]string
+		if err := json.Unmarshal([]byte(env), &envMapping); err == nil {
+			for k, v := range envMapping {
+				mapping[k] = v
+			}
+		}
+	}
+	if len(mapping) == 0 {
+		mapping = defaultModelMapping()
+	}
+	return mapping
+}
+
+// defaultModelMapping is used when no model_map.json/MODEL_MAP is configured.
+func defaultModelMapping() map[string]string {
+	return map[string]string{
+		"gpt-4o":            "GPT4O",
+		"gpt-4o-mini":       "GPT4O_MINI",
+		"claude-3-5-sonnet": "CLAUDE_V3_5_SONNET",
+		"claude-3-opus":     "CLAUDE_V3_OPUS",
+		"gemini-1.5-pro":    "GEMINI_1_5_PRO",
+	}
+}
+
+// NewModelMap wraps a mapping of openAIModel -> abacus llmName.
+func NewModelMap(mapping map[string]string) *ModelMap {
+	return &ModelMap{mapping: mapping}
+}
+
+// Resolve returns the Abacus llmName for an OpenAI model id, or the id
+// itself when there's no mapping.
+func (m *ModelMap) Resolve(openAIModel string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if llmName, ok := m.mapping[openAIModel]; ok {
+		return llmName
+	}
+	return openAIModel
+}
+
+// Models lists the OpenAI-facing model ids, sorted, for GET /v1/models.
+func (m *ModelMap) Models() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	models := make([]string, 0, len(m.mapping))
+	for model := range m.mapping {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
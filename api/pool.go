@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cookiesFile    = "cookies.txt"
+	cookiesEnvVar  = "COOKIES"
+	baseQuarantine = 5 * time.Second
+	maxQuarantine  = 5 * time.Minute
+)
+
+var errNoHealthyCookies = errors.New("没有可用的健康 cookie")
+
+// CookieState tracks the health of a single cookie in the pool.
+type CookieState struct {
+	Cookie           string    `json:"-"`
+	FailCount        int       `json:"failCount"`
+	QuarantinedUntil time.Time `json:"quarantinedUntil,omitempty"`
+	LastError        string    `json:"lastError,omitempty"`
+	LastUsed         time.Time `json:"lastUsed,omitempty"`
+}
+
+func (s *CookieState) quarantined(now time.Time) bool {
+	return now.Before(s.QuarantinedUntil)
+}
+
+// Pool is a round-robin collection of Abacus cookies with per-cookie
+// failure tracking and quarantine/backoff.
+type Pool struct {
+	mu      sync.Mutex
+	cookies []*CookieState
+	next    int
+}
+
+// defaultPool is populated from cookies.txt and the COOKIES env var at
+// startup, and can be hot-reloaded via the admin API.
+var defaultPool = NewPool(loadCookieSeeds())
+
+// loadCookieSeeds reads cookies.txt (one cookie per line) and the COOKIES
+// env var (newline separated), in that order.
+func loadCookieSeeds() []string {
+	var seeds []string
+	if f, err := os.Open(cookiesFile); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				seeds = append(seeds, line)
+			}
+		}
+		f.Close()
+	}
+	if env := strings.TrimSpace(os.Getenv(cookiesEnvVar)); env != "" {
+		for _, c := range strings.Split(env, "\n") {
+			c = strings.TrimSpace(c)
+			if c != "" {
+				seeds = append(seeds, c)
+			}
+		}
+	}
+	return seeds
+}
+
+// NewPool builds a pool from the given cookies.
+func NewPool(cookies []string) *Pool {
+	p := &Pool{}
+	p.Load(cookies)
+	return p
+}
+
+// Load replaces the pool's cookies, e.g. from the admin API.
+func (p *Pool) Load(cookies []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cookies = make([]*CookieState, 0, len(cookies))
+	for _, c := range cookies {
+		p.cookies = append(p.cookies, &CookieState{Cookie: c})
+	}
+	p.next = 0
+}
+
+// Client is a cookie picked from the pool for a single outbound request.
+type Client struct {
+	pool  *Pool
+	state *CookieState
+}
+
+// Pick selects the next non-quarantined cookie using round-robin.
+func (p *Pool) Pick() (*Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.cookies)
+	if n == 0 {
+		return nil, errNoHealthyCookies
+	}
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		state := p.cookies[idx]
+		if !state.quarantined(now) {
+			p.next = (idx + 1) % n
+			state.LastUsed = now
+			return &Client{pool: p, state: state}, nil
+		}
+	}
+	return nil, errNoHealthyCookies
+}
+
+// PickByIdentity returns the Client for a specific cookie, bypassing
+// round-robin, so a cached conversation can be replayed against the same
+// credential that created it. It fails if the cookie was removed from the
+// pool or is currently quarantined.
+func (p *Pool) PickByIdentity(identity string) (*Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, state := range p.cookies {
+		if state.Cookie != identity {
+			continue
+		}
+		if state.quarantined(now) {
+			return nil, errNoHealthyCookies
+		}
+		state.LastUsed = now
+		return &Client{pool: p, state: state}, nil
+	}
+	return nil, errNoHealthyCookies
+}
+
+// Cookie returns the raw cookie string to send upstream.
+func (c *Client) Cookie() string {
+	return c.state.Cookie
+}
+
+// Apply sets the outbound request headers for this cookie, satisfying
+// AuthSession.
+func (c *Client) Apply(req *http.Request) {
+	setHeaders(req, c.state.Cookie)
+}
+
+// Identity returns a stable string identifying this credential (the raw
+// cookie). It is recorded on ConversationEntry.Identity; it is not part of
+// the conversation key, so a cached conversation is found regardless of
+// which cookie asks, and is then pinned to this one via PickByIdentity.
+func (c *Client) Identity() string {
+	return c.state.Cookie
+}
+
+// ReportFailure quarantines the cookie with exponential backoff. Call this
+// when createConversation or _chatLLMSendMessageSSE returns 401/403/429 or
+// a non-success JSON body.
+func (c *Client) ReportFailure(statusCode int, err error) {
+	p := c.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := c.state
+	s.FailCount++
+	if err != nil {
+		s.LastError = err.Error()
+	} else {
+		s.LastError = fmt.Sprintf("upstream status %d", statusCode)
+	}
+	shift := s.FailCount - 1
+	if shift > 10 {
+		shift = 10
+	}
+	backoff := baseQuarantine * time.Duration(int64(1)<<uint(shift))
+	if backoff > maxQuarantine {
+		backoff = maxQuarantine
+	}
+	s.QuarantinedUntil = time.Now().Add(backoff)
+}
+
+// ReportSuccess clears the failure state of a cookie that just worked.
+func (c *Client) ReportSuccess() {
+	p := c.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.state.FailCount = 0
+	c.state.QuarantinedUntil = time.Time{}
+	c.state.LastError = ""
+}
+
+// Remove drops the cookie at idx from the pool.
+func (p *Pool) Remove(idx int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if idx < 0 || idx >= len(p.cookies) {
+		return fmt.Errorf("索引超出范围: %d", idx)
+	}
+	p.cookies = append(p.cookies[:idx], p.cookies[idx+1:]...)
+	if p.next > len(p.cookies) {
+		p.next = 0
+	}
+	return nil
+}
+
+// CookieStatus is the admin-facing view of a single pool entry.
+type CookieStatus struct {
+	Index            int       `json:"index"`
+	Healthy          bool      `json:"healthy"`
+	FailCount        int       `json:"failCount"`
+	QuarantinedUntil time.Time `json:"quarantinedUntil,omitempty"`
+	LastError        string    `json:"lastError,omitempty"`
+	LastUsed         time.Time `json:"lastUsed,omitempty"`
+}
+
+// Snapshot reports the state of every cookie in the pool.
+func (p *Pool) Snapshot() []CookieStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	statuses := make([]CookieStatus, 0, len(p.cookies))
+	for i, s := range p.cookies {
+		statuses = append(statuses, CookieStatus{
+			Index:            i,
+			Healthy:          !s.quarantined(now),
+			FailCount:        s.FailCount,
+			QuarantinedUntil: s.QuarantinedUntil,
+			LastError:        s.LastError,
+			LastUsed:         s.LastUsed,
+		})
+	}
+	return statuses
+}
+
+// Reprobe clears LastError on every cookie whose quarantine has expired; it
+// is called periodically by startReprobeLoop. It sends nothing upstream and
+// leaves FailCount and QuarantinedUntil as they are: Pick takes a cookie
+// back on its own once QuarantinedUntil has passed, whether or not Reprobe
+// has run.
+func (p *Pool) Reprobe() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, s := range p.cookies {
+		if !s.QuarantinedUntil.IsZero() && now.After(s.QuarantinedUntil) {
+			s.LastError = ""
+		}
+	}
+}
+
+func startReprobeLoop(p *Pool, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.Reprobe()
+		}
+	}()
+}
+
+func init() {
+	startReprobeLoop(defaultPool, 30*time.Second)
+}
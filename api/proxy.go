@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	proxiesFile   = "proxies.txt"
+	proxyEnvVar   = "PROXY"
+	proxyUnhealth = 2 * time.Minute
+)
+
+// proxyState tracks whether a proxy is currently usable.
+type proxyState struct {
+	rawURL           string
+	client           *http.Client
+	quarantinedUntil time.Time
+}
+
+// ProxyPool rotates outbound requests across a set of HTTP/SOCKS5 proxies,
+// caching one *http.Client per proxy URL.
+type ProxyPool struct {
+	mu      sync.Mutex
+	proxies []*proxyState
+	next    int
+}
+
+// defaultProxyPool is populated from proxies.txt and the PROXY env var at
+// startup. An empty pool means "dial directly" (no proxy).
+var defaultProxyPool = NewProxyPool(loadProxySeeds())
+
+func loadProxySeeds() []string {
+	var seeds []string
+	if f, err := os.Open(proxiesFile); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				seeds = append(seeds, line)
+			}
+		}
+		f.Close()
+	}
+	if env := strings.TrimSpace(os.Getenv(proxyEnvVar)); env != "" {
+		for _, p := range strings.Split(env, "\n") {
+			p = strings.TrimSpace(p)
+			if p != "" {
+				seeds = append(seeds, p)
+			}
+		}
+	}
+	return seeds
+}
+
+// NewProxyPool builds http.Clients for each proxy URL, skipping any that
+// fail to parse.
+func NewProxyPool(rawURLs []string) *ProxyPool {
+	p := &ProxyPool{}
+	for _, raw := range rawURLs {
+		client, err := newProxyClient(raw)
+		if err != nil {
+			continue
+		}
+		p.proxies = append(p.proxies, &proxyState{rawURL: raw, client: client})
+	}
+	return p
+}
+
+// newProxyClient builds an *http.Client that dials through the given proxy
+// URL, supporting http://, https:// and socks5:// schemes.
+func newProxyClient(rawURL string) (*http.Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(u)},
+		}, nil
+	case "socks5":
+		var auth *proxy.Auth
+		if u.User != nil {
+			auth = &proxy.Auth{User: u.User.Username()}
+			if pass, ok := u.User.Password(); ok {
+				auth.Password = pass
+			}
+		}
+		dialer, err := proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{
+			Transport: &http.Transport{Dial: dialer.Dial},
+		}, nil
+	default:
+		return nil, fmt.Errorf("不支持的代理协议: %s", u.Scheme)
+	}
+}
+
+// Pick returns an *http.Client for the next healthy proxy, or http.DefaultClient
+// when the pool is empty (no proxy configured).
+func (p *ProxyPool) Pick() *http.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.proxies)
+	if n == 0 {
+		return http.DefaultClient
+	}
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		state := p.proxies[idx]
+		if now.After(state.quarantinedUntil) {
+			p.next = (idx + 1) % n
+			return state.client
+		}
+	}
+	// All proxies are quarantined; fall back to the least-recently failed one
+	// rather than dialing directly and exposing our real egress IP.
+	oldest := p.proxies[0]
+	for _, state := range p.proxies[1:] {
+		if state.quarantinedUntil.Before(oldest.quarantinedUntil) {
+			oldest = state
+		}
+	}
+	return oldest.client
+}
+
+// ReportFailure quarantines the client that produced a dial/timeout error so
+// future picks fall back to another proxy.
+func (p *ProxyPool) ReportFailure(client *http.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, state := range p.proxies {
+		if state.client == client {
+			state.quarantinedUntil = time.Now().Add(proxyUnhealth)
+			return
+		}
+	}
+}
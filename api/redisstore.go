@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "abacus2api:conv:"
+
+// redisConversationStore is the ConversationStore backend selected with
+// CONVERSATION_STORE=redis, for sharing conversation state across instances.
+type redisConversationStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func newRedisConversationStore(ttl time.Duration) (*redisConversationStore, error) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: os.Getenv("REDIS_PASSWORD"),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+
+	return &redisConversationStore{client: client, ttl: ttl}, nil
+}
+
+func (s *redisConversationStore) Get(key string) (ConversationEntry, bool) {
+	data, err := s.client.Get(context.Background(), redisKeyPrefix+key).Bytes()
+	if err != nil {
+		return ConversationEntry{}, false
+	}
+	var entry ConversationEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return ConversationEntry{}, false
+	}
+	return entry, true
+}
+
+func (s *redisConversationStore) Set(key string, entry ConversationEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	s.client.Set(context.Background(), redisKeyPrefix+key, data, s.ttl)
+}
+
+func (s *redisConversationStore) Delete(key string) {
+	s.client.Del(context.Background(), redisKeyPrefix+key)
+}
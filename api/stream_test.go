@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testClient(t *testing.T) *Client {
+	t.Helper()
+	pool := NewPool([]string{"test-cookie"})
+	client, err := pool.Pick()
+	if err != nil {
+		t.Fatalf("pool.Pick() failed: %v", err)
+	}
+	return client
+}
+
+func withAbacusChatURL(t *testing.T, url string) {
+	t.Helper()
+	original := abacusChatURL
+	abacusChatURL = url
+	t.Cleanup(func() { abacusChatURL = original })
+}
+
+func withStreamIdleTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	original := streamIdleTimeout
+	streamIdleTimeout = d
+	t.Cleanup(func() { streamIdleTimeout = original })
+}
+
+func TestSendStreamResponseFlushesRoleContentAndFinish(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		w.Write([]byte(`{"type":"text","segment":"Hello"}` + "\n"))
+		flusher.Flush()
+		w.Write([]byte(`{"type":"text","segment":" world","end":true}` + "\n"))
+		flusher.Flush()
+	}))
+	defer upstream.Close()
+	withAbacusChatURL(t, upstream.URL)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	rec := httptest.NewRecorder()
+
+	err := sendStreamResponse(rec, req, testClient(t), ChatRequest{}, "gpt-4o")
+	if err != nil {
+		t.Fatalf("sendStreamResponse returned error: %v", err)
+	}
+	if !rec.Flushed {
+		t.Fatal("expected the response writer to have been flushed")
+	}
+
+	body := rec.Body.String()
+	frames := parseSSEFrames(t, body)
+	if len(frames) != 5 {
+		t.Fatalf("expected 5 SSE frames (role, 2 content, finish, [DONE]), got %d: %q", len(frames), body)
+	}
+	if frames[0].Choices[0].Delta.Role != "assistant" {
+		t.Errorf("first frame should carry the assistant role, got %+v", frames[0])
+	}
+	if got := frames[1].Choices[0].Delta.Content + frames[2].Choices[0].Delta.Content; got != "Hello world" {
+		t.Errorf("expected concatenated content deltas \"Hello world\", got %q", got)
+	}
+	if frames[3].Choices[0].FinishReason != "stop" {
+		t.Errorf("expected finish_reason=stop on the fourth frame, got %+v", frames[3])
+	}
+	if !strings.Contains(body, "data: [DONE]") {
+		t.Error("expected a trailing [DONE] frame")
+	}
+}
+
+func TestSendStreamResponseCancellation(t *testing.T) {
+	released := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-released
+	}))
+	defer upstream.Close()
+	defer close(released)
+	withAbacusChatURL(t, upstream.URL)
+	withStreamIdleTimeout(t, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sendStreamResponse(rec, req, testClient(t), ChatRequest{}, "gpt-4o")
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected a context.Canceled error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sendStreamResponse did not return after client cancellation")
+	}
+}
+
+func TestSendStreamResponseIdleTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		time.Sleep(time.Second)
+	}))
+	defer upstream.Close()
+	withAbacusChatURL(t, upstream.URL)
+	withStreamIdleTimeout(t, 30*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	rec := httptest.NewRecorder()
+
+	err := sendStreamResponse(rec, req, testClient(t), ChatRequest{}, "gpt-4o")
+	if err == nil {
+		t.Fatal("expected an idle-timeout error")
+	}
+	if !strings.Contains(rec.Body.String(), "upstream_error") {
+		t.Errorf("expected an SSE error event in the body, got %q", rec.Body.String())
+	}
+}
+
+// TestSendStreamResponseUpstreamResetIsReportedAsError pins down a race
+// between the reader goroutine's readErr send and its immediately-following
+// close(lines): when both become ready at once, the consumer select must
+// not let the closed lines channel win and report a dropped connection as a
+// clean [DONE] completion.
+func TestSendStreamResponseUpstreamResetIsReportedAsError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		w.Write([]byte(`{"type":"text","segment":"partial"}` + "\n"))
+		flusher.Flush()
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		conn.Close()
+	}))
+	defer upstream.Close()
+	withAbacusChatURL(t, upstream.URL)
+	withStreamIdleTimeout(t, time.Minute)
+
+	for i := 0; i < 20; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		rec := httptest.NewRecorder()
+
+		err := sendStreamResponse(rec, req, testClient(t), ChatRequest{}, "gpt-4o")
+		if err == nil {
+			t.Fatal("expected the connection reset to be reported as an error, got nil (treated as a clean completion)")
+		}
+		if strings.Contains(rec.Body.String(), "data: [DONE]") {
+			t.Fatalf("connection reset must not be reported to the client as a clean completion, got %q", rec.Body.String())
+		}
+	}
+}
+
+func parseSSEFrames(t *testing.T, body string) []OpenAIStreamResponse {
+	t.Helper()
+	var frames []OpenAIStreamResponse
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == "[DONE]" {
+			frames = append(frames, OpenAIStreamResponse{})
+			continue
+		}
+		var frame OpenAIStreamResponse
+		if err := json.Unmarshal([]byte(payload), &frame); err != nil {
+			t.Fatalf("failed to parse SSE frame %q: %v", payload, err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// countTokens estimates the token count of text using tiktoken's cl100k_base
+// encoding (the one used by gpt-4o-class models), falling back to a
+// char-count heuristic when the BPE ranks can't be loaded (e.g. no network
+// access to download them).
+func countTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	enc, err := tiktoken.GetEncoding("cl100k_base")
+	if err != nil {
+		return fallbackTokenCount(text)
+	}
+	return len(enc.Encode(text, nil, nil))
+}
+
+// fallbackTokenCount approximates token count at ~4 characters per token.
+func fallbackTokenCount(text string) int {
+	n := utf8.RuneCountInString(text) / 4
+	if n == 0 {
+		n = 1
+	}
+	return n
+}